@@ -1,18 +1,30 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"prom_tg_alerts/internal/format"
 	"prom_tg_alerts/internal/labels"
+	"prom_tg_alerts/internal/metrics"
+	"prom_tg_alerts/internal/queue"
+	"prom_tg_alerts/internal/route"
+	"prom_tg_alerts/internal/track"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	cli "github.com/jawher/mow.cli"
 )
@@ -50,8 +62,122 @@ var (
 		EnvVar: "FREQUENCY",
 		Value:  15,
 	})
+	mode = app.String(cli.StringOpt{
+		Name:   "mode",
+		Desc:   "How alerts are received: \"poll\" the Prometheus Alerts URL, or \"webhook\" to run an HTTP server that receives Alertmanager webhook pushes",
+		EnvVar: "MODE",
+		Value:  "poll",
+	})
+	listenAddr = app.String(cli.StringOpt{
+		Name:   "listen-addr",
+		Desc:   "Address to listen on in --mode=webhook",
+		EnvVar: "LISTEN_ADDR",
+		Value:  ":8080",
+	})
+	webhookPath = app.String(cli.StringOpt{
+		Name:   "webhook-path",
+		Desc:   "HTTP path Alertmanager's webhook receiver posts to in --mode=webhook",
+		EnvVar: "WEBHOOK_PATH",
+		Value:  "/webhook",
+	})
+	webhookSecret = app.String(cli.StringOpt{
+		Name:   "webhook-secret",
+		Desc:   "If set, webhook requests must carry this value in the X-Webhook-Secret header",
+		EnvVar: "WEBHOOK_SECRET",
+		Value:  "",
+	})
+	msgTemplatePath = app.String(cli.StringOpt{
+		Name:   "msg-template",
+		Desc:   "Path to a text/template file rendering one Telegram message per alert group; defaults to today's layout",
+		EnvVar: "MSG_TEMPLATE",
+		Value:  "",
+	})
+	groupTemplatePath = app.String(cli.StringOpt{
+		Name:   "group-template",
+		Desc:   "Path to a text/template file rendering a group's display name",
+		EnvVar: "GROUP_TEMPLATE",
+		Value:  "",
+	})
+	parseMode = app.String(cli.StringOpt{
+		Name:   "parse-mode",
+		Desc:   "Telegram parse_mode to send with, and to escape SafeMarkdown for: Markdown, MarkdownV2, or HTML",
+		EnvVar: "PARSE_MODE",
+		Value:  "Markdown",
+	})
+	routesFile = app.String(cli.StringOpt{
+		Name:   "routes-file",
+		Desc:   "Path to a YAML routing table sending alert groups to different chats/bots based on labels; without it every group goes to -t/-c",
+		EnvVar: "ROUTES_FILE",
+		Value:  "",
+	})
+	queueDir = app.String(cli.StringOpt{
+		Name:   "queue-dir",
+		Desc:   "Directory to persist the Telegram retry queue's write-ahead log in; without it the queue is in-memory only",
+		EnvVar: "QUEUE_DIR",
+		Value:  "",
+	})
+	queueCapacity = app.Int(cli.IntOpt{
+		Name:   "queue-capacity",
+		Desc:   "Maximum number of undelivered alerts to hold before dropping the oldest",
+		EnvVar: "QUEUE_CAPACITY",
+		Value:  1000,
+	})
+	queueMaxAge = app.String(cli.StringOpt{
+		Name:   "queue-max-age",
+		Desc:   "Drop queued alerts older than this duration (e.g. \"24h\"); 0 disables age-based dropping",
+		EnvVar: "QUEUE_MAX_AGE",
+		Value:  "24h",
+	})
+	metricsAddr = app.String(cli.StringOpt{
+		Name:   "metrics-addr",
+		Desc:   "If set, serve Prometheus metrics about the notifier itself on this address at /metrics",
+		EnvVar: "METRICS_ADDR",
+		Value:  "",
+	})
+	resolvedTemplatePath = app.String(cli.StringOpt{
+		Name:   "resolved-template",
+		Desc:   "Path to a text/template file rendering resolved alerts; defaults to a RESOLVED-prefixed variant of the default message layout, whether or not --msg-template is set",
+		EnvVar: "RESOLVED_TEMPLATE",
+		Value:  "",
+	})
+	resendIntervalFlag = app.String(cli.StringOpt{
+		Name:   "resend-interval",
+		Desc:   "Periodically resend still-firing alerts as a heartbeat after this much time (e.g. \"1h\"); 0 disables resending",
+		EnvVar: "RESEND_INTERVAL",
+		Value:  "0",
+	})
 )
 
+// formatter renders alert groups into Telegram message bodies. It is built
+// once, from --msg-template/--group-template/--parse-mode, before the
+// notifier starts polling or serving webhooks.
+var formatter *format.Formatter
+
+// routingConfig is the routing table loaded from --routes-file, or nil if
+// unset, in which case every group is sent to the single -t/-c destination.
+var routingConfig *route.Config
+
+// notifyQueue durably buffers outgoing Telegram messages and retries them
+// across rate limits and outages; see sendNotification and sendTelegram.
+var notifyQueue *queue.Queue
+
+// alertTracker classifies each poll's alerts into newly firing, still
+// firing, and resolved, per group, so only the delta is notified.
+var alertTracker *track.Tracker
+
+// resendInterval is how often a still-firing alert is re-announced as a
+// heartbeat; see --resend-interval.
+var resendInterval time.Duration
+
+// lastScrapeError remembers the previous poll's scrape error, if any, so
+// action only notifies on a transition into or out of an error state
+// instead of once per --frequency tick.
+var lastScrapeError string
+
+// maxWebhookBodyBytes bounds how much of a single webhook POST body we will
+// read, to protect the process from oversized or runaway requests.
+const maxWebhookBodyBytes = 1 << 20 // 1MB
+
 // Alert is a generic representation of an alert in the Prometheus eco-system.
 type Alert struct {
 	// Label value pairs for purpose of aggregation, matching, and disposition
@@ -65,6 +191,11 @@ type Alert struct {
 	StartsAt     time.Time `json:"startsAt,omitempty"`
 	EndsAt       time.Time `json:"endsAt,omitempty"`
 	GeneratorURL string    `json:"generatorURL,omitempty"`
+
+	// Status is only populated for alerts sourced from an Alertmanager
+	// webhook push, which reports it explicitly ("firing" or "resolved").
+	// Polled alerts are classified via alertTracker instead.
+	Status string `json:"status,omitempty"`
 }
 
 type alertState struct {
@@ -84,26 +215,31 @@ func NewAlertState(url string) *alertState {
 	res, err := http.Get(url)
 	if err != nil {
 		out.Error = err.Error()
+		metrics.ScrapeErrors.Inc()
 		return out
 	}
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		out.Error = err.Error()
+		metrics.ScrapeErrors.Inc()
 		return out
 	}
 	var data response
 	if err := json.Unmarshal(body, &data); err != nil {
 		log.Printf("prometheus response %v\n", string(body))
 		out.Error = "Failed to get response from Prometheus"
+		metrics.ScrapeErrors.Inc()
 		return out
 	}
 
 	if len(data.Error) > 0 {
 		out.Error = data.Error
+		metrics.ScrapeErrors.Inc()
 		return out
 	}
 	log.Printf("%v alerts from %v\n", len(data.Data.Alerts), url)
 	out.Alerts = data.Data.Alerts
+	metrics.AlertsScraped.Add(float64(len(out.Alerts)))
 	return out
 }
 
@@ -142,64 +278,298 @@ func (s *alertState) Groupped() map[string][]*Alert {
 	return out
 }
 
-func alertToString(src *Alert) string {
-	out := make([]string, 0)
-	if src.Annotations != nil {
-		summary := src.Annotations.Get("summary")
-		if len(summary) > 0 {
-			out = append(out, "• *"+summary+"*")
-		}
-		description := src.Annotations.Get("description")
-		if len(description) > 0 {
-			out = append(out, description)
+func toAlertView(a *Alert) format.AlertView {
+	return format.AlertView{
+		Labels:       a.Labels,
+		Annotations:  a.Annotations,
+		StartsAt:     a.StartsAt,
+		EndsAt:       a.EndsAt,
+		GeneratorURL: a.GeneratorURL,
+	}
+}
+
+// commonLabels returns the labels shared by every alert in a group, used
+// to evaluate routing matches against the group as a whole.
+func commonLabels(alerts []*Alert) labels.Labels {
+	if len(alerts) == 0 {
+		return nil
+	}
+	common := labels.Labels{}
+	for _, l := range alerts[0].Labels {
+		shared := true
+		for _, a := range alerts[1:] {
+			if a.Labels.Get(l.Name) != l.Value {
+				shared = false
+				break
+			}
 		}
-		if len(out) == 0 {
-			out = append(out, src.Annotations.String())
+		if shared {
+			common = append(common, l)
 		}
 	}
-	if len(out) == 0 {
-		out = append(out, src.Labels.String())
+	return common
+}
+
+// fingerprint is a stable identity for an alert derived from its label
+// set, mirroring Alertmanager's own model.Fingerprint.
+func fingerprint(a *Alert) track.Fingerprint {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(a.Labels.String()))
+	return track.Fingerprint(strconv.FormatUint(h.Sum64(), 16))
+}
+
+// snapshotAlert captures an alert's current content so a later resolved
+// notification can still render it after Prometheus stops reporting it.
+func snapshotAlert(a *Alert) json.RawMessage {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+func alertFromSnapshot(raw json.RawMessage) *Alert {
+	var a Alert
+	if err := json.Unmarshal(raw, &a); err != nil {
+		return nil
 	}
-	return strings.Join(out, "\n")
+	return &a
 }
 
 const msgSizeLimit = 3500
 
-func (s *alertState) Messages() map[string]string {
-	out := map[string]string{}
-	if s.Error != "" {
-		out[""] = "ERROR: " + s.Error
-	}
-	for key, group := range s.Groupped() {
-		msgSize := 0
-		rows := make([]string, 0)
-		for _, a := range group {
-			if msgSize < msgSizeLimit {
-				alert := alertToString(a)
-				rows = append(rows, alert)
-				msgSize += len(alert) + 2
+// truncate cuts msg longer than msgSizeLimit bytes down to at most
+// msgSizeLimit bytes and appends "...". It cuts right after the last line
+// boundary (an alert row, in a rendered group) before the limit, where
+// there is one, so rows aren't chopped mid-entry, and never mid-rune or
+// right after a "\" so UTF-8 and Markdown escapes (e.g. "\" + a multi-byte
+// character) can't be split in two.
+func truncate(msg string) string {
+	if len(msg) <= msgSizeLimit {
+		return msg
+	}
+	cut := msgSizeLimit
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	for cut > 0 && msg[cut-1] == '\\' {
+		cut--
+	}
+	if nl := strings.LastIndexByte(msg[:cut], '\n'); nl > 0 {
+		cut = nl + 1
+	}
+	return msg[:cut] + "..."
+}
+
+func toAlertViews(alerts []*Alert) []format.AlertView {
+	views := make([]format.AlertView, 0, len(alerts))
+	for _, a := range alerts {
+		views = append(views, toAlertView(a))
+	}
+	return views
+}
+
+func renderGroup(key string, alerts []*Alert) (string, error) {
+	msg, err := formatter.Render(format.GroupView{GroupKey: key, Alerts: toAlertViews(alerts)})
+	if err != nil {
+		return "", err
+	}
+	return truncate(msg), nil
+}
+
+func renderResolvedGroup(key string, alerts []*Alert) (string, error) {
+	msg, err := formatter.RenderResolved(format.GroupView{GroupKey: key, Alerts: toAlertViews(alerts)})
+	if err != nil {
+		return "", err
+	}
+	return truncate(msg), nil
+}
+
+// renderGroupKey runs key through --group-template, falling back to the
+// raw key if the template fails so a bad template can't swallow a log line.
+func renderGroupKey(key string, alerts []*Alert) string {
+	rendered, err := formatter.RenderGroupKey(format.GroupView{GroupKey: key, Alerts: toAlertViews(alerts)})
+	if err != nil {
+		log.Println("[ERR] rendering group template", err)
+		return key
+	}
+	return rendered
+}
+
+// defaultDestination is the single -t/-c/--parse-mode destination used
+// when --routes-file is not set, or as the fallback for routeless errors.
+func defaultDestination() route.Destination {
+	return route.Destination{ChatID: *tgChatId, BotToken: *tgBotToken, ParseMode: *parseMode}
+}
+
+// resolveDestinations returns where a group's alerts should be sent.
+func resolveDestinations(alerts []*Alert) []route.Destination {
+	if routingConfig == nil {
+		return []route.Destination{defaultDestination()}
+	}
+	return routingConfig.Resolve(commonLabels(alerts))
+}
+
+// notification is one rendered message bound for one destination.
+type notification struct {
+	dest       route.Destination
+	key        string
+	displayKey string
+	msg        string
+}
+
+// classifyGroup compares a group's currently-firing alerts against
+// alertTracker's memory of what was firing last poll, returning the
+// alerts that should be announced as firing (new, plus any due for a
+// --resend-interval heartbeat) and as resolved.
+func classifyGroup(key string, alerts []*Alert) (firing []*Alert, resolved []*Alert) {
+	byFingerprint := make(map[track.Fingerprint]*Alert, len(alerts))
+	current := make([]track.CurrentAlert, 0, len(alerts))
+	for _, a := range alerts {
+		fp := fingerprint(a)
+		byFingerprint[fp] = a
+		current = append(current, track.CurrentAlert{Fingerprint: fp, Snapshot: snapshotAlert(a)})
+	}
+
+	result := alertTracker.Update(key, current, resendInterval, time.Now())
+	for _, c := range result.NewlyFiring {
+		firing = append(firing, byFingerprint[c.Fingerprint])
+	}
+	for _, c := range result.StillFiring {
+		firing = append(firing, byFingerprint[c.Fingerprint])
+	}
+	for _, raw := range result.Resolved {
+		if a := alertFromSnapshot(raw); a != nil {
+			resolved = append(resolved, a)
+		}
+	}
+	return firing, resolved
+}
+
+// notifyGroup renders and routes a group's firing and resolved alerts
+// separately, since they use distinct templates.
+func notifyGroup(key string, firing []*Alert, resolved []*Alert) {
+	if len(firing) > 0 {
+		msg, err := renderGroup(key, firing)
+		if err != nil {
+			log.Println("[ERR] rendering msg template", err)
+		} else {
+			metrics.StateChanges.Inc()
+			displayKey := renderGroupKey(key, firing)
+			for _, d := range resolveDestinations(firing) {
+				sendNotification(notification{dest: d, key: key, displayKey: displayKey, msg: msg})
 			}
 		}
-		out[key] = strings.Join(rows, "\n")
-		if len(out[key]) >= msgSizeLimit {
-			out[key] = out[key] + "..."
+	}
+	if len(resolved) > 0 {
+		msg, err := renderResolvedGroup(key, resolved)
+		if err != nil {
+			log.Println("[ERR] rendering resolved template", err)
+		} else {
+			metrics.StateChanges.Inc()
+			displayKey := renderGroupKey(key, resolved)
+			for _, d := range resolveDestinations(resolved) {
+				sendNotification(notification{dest: d, key: key, displayKey: displayKey, msg: msg})
+			}
 		}
 	}
-	if len(out) == 0 {
-		out[""] = "NO ALERTS"
+}
+
+// webhookAlert mirrors a single entry of Alertmanager's webhook `alerts`
+// array. See https://prometheus.io/docs/alerting/latest/configuration/#webhook_config.
+type webhookAlert struct {
+	Status       string        `json:"status"`
+	Labels       labels.Labels `json:"labels"`
+	Annotations  labels.Labels `json:"annotations"`
+	StartsAt     time.Time     `json:"startsAt"`
+	EndsAt       time.Time     `json:"endsAt"`
+	GeneratorURL string        `json:"generatorURL"`
+}
+
+// webhookPayload is the body Alertmanager POSTs to a webhook receiver.
+type webhookPayload struct {
+	Version           string         `json:"version"`
+	GroupKey          string         `json:"groupKey"`
+	Status            string         `json:"status"`
+	Receiver          string         `json:"receiver"`
+	GroupLabels       labels.Labels  `json:"groupLabels"`
+	CommonLabels      labels.Labels  `json:"commonLabels"`
+	CommonAnnotations labels.Labels  `json:"commonAnnotations"`
+	ExternalURL       string         `json:"externalURL"`
+	Alerts            []webhookAlert `json:"alerts"`
+}
+
+func (a webhookAlert) toAlert() *Alert {
+	return &Alert{
+		Labels:       a.Labels,
+		Annotations:  a.Annotations,
+		StartsAt:     a.StartsAt,
+		EndsAt:       a.EndsAt,
+		GeneratorURL: a.GeneratorURL,
+		Status:       a.Status,
 	}
-	return out
 }
 
-func (s *alertState) StateHash() string {
-	out := ""
-	if len(s.Error) > 0 {
-		out += "&error=" + s.Error
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if *webhookSecret != "" && r.Header.Get("X-Webhook-Secret") != *webhookSecret {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
 	}
-	for _, a := range s.SortedAlerts() {
-		out += "&" + a.Labels.String()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
 	}
-	return out
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "malformed webhook payload", http.StatusBadRequest)
+		return
+	}
+
+	state := NewInitialState()
+	for _, a := range payload.Alerts {
+		state.Alerts = append(state.Alerts, a.toAlert())
+	}
+	log.Printf("[WEBHOOK] groupKey=%v status=%v receiver=%v alerts=%v\n",
+		payload.GroupKey, payload.Status, payload.Receiver, len(state.Alerts))
+	dispatch(state)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveWebhook runs an HTTP server that receives Alertmanager webhook
+// pushes and dispatches them to Telegram immediately, in place of polling
+// the Prometheus Alerts URL.
+func serveWebhook() {
+	mux := http.NewServeMux()
+	mux.HandleFunc(*webhookPath, webhookHandler)
+	srv := &http.Server{
+		Addr:    *listenAddr,
+		Handler: mux,
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		<-sigCh
+		log.Println("[INFO] shutting down webhook server")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("[ERR] webhook server shutdown", err)
+		}
+		close(shutdownDone)
+	}()
+
+	log.Printf("[INFO] listening for Alertmanager webhooks on %v%v\n", *listenAddr, *webhookPath)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalln("[ERR]", err)
+	}
+	<-shutdownDone
 }
 
 type responseData struct {
@@ -218,53 +588,210 @@ func rawurlencode(str string) string {
 	return strings.Replace(url.QueryEscape(str), "+", "%20", -1)
 }
 
-func sendTelegram(from string, chatID string, body []byte) error {
+// parseRetryAfter parses Telegram's 429 Retry-After header, which is a
+// whole number of seconds to wait before trying again.
+func parseRetryAfter(header string) time.Duration {
+	secs, err := strconv.Atoi(header)
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func sendTelegram(from string, chatID string, parseMode string, body []byte) queue.Result {
+	start := time.Now()
+	result := "error"
+	defer func() {
+		metrics.TelegramSendDuration.Observe(time.Since(start).Seconds())
+		metrics.TelegramSendTotal.WithLabelValues(result).Inc()
+	}()
+
 	if len(body) > 3600 {
-		return errors.New("message too long")
+		log.Println("[ERR] Notification failure", errors.New("message too long"))
+		return queue.Result{OK: false}
 	}
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage?chat_id=%s&parse_mode=Markdown&text=%s",
-		from, chatID, rawurlencode(string(body)))
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage?chat_id=%s&parse_mode=%s&text=%s",
+		from, chatID, parseMode, rawurlencode(string(body)))
 	log.Println("[URL]=", url)
 
 	resp, err := http.Get(url)
 	if err != nil {
-		return err
+		log.Println("[ERR] Notification failure", err)
+		return queue.Result{OK: false}
 	}
 	defer resp.Body.Close()
 	output, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		log.Println("[ERR] Notification failure", err)
+		return queue.Result{OK: false}
 	}
-	_ = output
 	log.Println("[OUT]=", string(output))
-	return nil
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		result = "ratelimited"
+		return queue.Result{OK: false, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode >= 300 {
+		log.Printf("[ERR] Notification failure: telegram returned %v\n", resp.StatusCode)
+		return queue.Result{OK: false}
+	}
+	log.Println("[OK] sent")
+	result = "ok"
+	return queue.Result{OK: true}
+}
+
+// sendNotification queues a single rendered message for delivery to its
+// destination, falling back to the notifier's own bot token/parse mode
+// where the destination didn't override them.
+func sendNotification(n notification) {
+	botToken := n.dest.BotToken
+	if botToken == "" {
+		botToken = *tgBotToken
+	}
+	destParseMode := n.dest.ParseMode
+	if destParseMode == "" {
+		destParseMode = *parseMode
+	}
+	displayKey := n.displayKey
+	if displayKey == "" {
+		displayKey = n.key
+	}
+	log.Printf("[MSG] dest=%v %v=%v msg=%v", n.dest.Key(), *groupBy, displayKey, n.msg)
+	notifyQueue.Enqueue(n.dest.ChatID, botToken, destParseMode, n.msg)
 }
 
+// dispatch routes and queues state's alerts, splitting each group by the
+// explicit status Alertmanager already reported for a webhook push; no
+// alertTracker diffing is needed since Alertmanager computed the
+// transition itself.
+func dispatch(state *alertState) {
+	if state.Error != "" {
+		sendNotification(notification{dest: defaultDestination(), msg: "ERROR: " + state.Error})
+		return
+	}
+	groupped := state.Groupped()
+	if len(groupped) == 0 {
+		sendNotification(notification{dest: defaultDestination(), msg: "NO ALERTS"})
+		return
+	}
+	for key, alerts := range groupped {
+		firing := make([]*Alert, 0, len(alerts))
+		resolved := make([]*Alert, 0)
+		for _, a := range alerts {
+			if a.Status == "resolved" {
+				resolved = append(resolved, a)
+			} else {
+				firing = append(firing, a)
+			}
+		}
+		notifyGroup(key, firing, resolved)
+	}
+}
+
+// action polls the Prometheus Alerts URL, classifies each group's alerts
+// against alertTracker's memory of the last poll, and notifies only the
+// newly-firing, due-for-resend, and resolved deltas.
 func action() {
-	prevState := NewInitialState()
 	for {
-		// read alertState from URL
 		state := NewAlertState(*prometheusAlertsURL)
-		if prevState.StateHash() != state.StateHash() {
-			// build correct messages for each instance
-			for key, msg := range state.Messages() {
-				log.Printf("[MSG] %v=%v msg=%v", *groupBy, key, msg)
-				// send message to telegram chat
-				if err := sendTelegram(*tgBotToken, *tgChatId, []byte(msg)); err != nil {
-					log.Println("[ERR] Notification failure", err)
-				} else {
-					log.Println("[OK] sent")
+		if state.Error != "" {
+			if state.Error != lastScrapeError {
+				sendNotification(notification{dest: defaultDestination(), msg: "ERROR: " + state.Error})
+				lastScrapeError = state.Error
+			}
+			time.Sleep(time.Duration(*frequency) * time.Second)
+			continue
+		}
+		if lastScrapeError != "" {
+			sendNotification(notification{dest: defaultDestination(), msg: "RECOVERED: scraping " + *prometheusAlertsURL + " succeeded again"})
+			lastScrapeError = ""
+		}
+
+		groupped := state.Groupped()
+		metrics.ActiveAlerts.Reset()
+		seenGroups := make(map[string]bool, len(groupped))
+		for key, alerts := range groupped {
+			seenGroups[key] = true
+			metrics.ActiveAlerts.WithLabelValues(key).Set(float64(len(alerts)))
+			firing, resolved := classifyGroup(key, alerts)
+			notifyGroup(key, firing, resolved)
+		}
+
+		for key, raws := range alertTracker.PruneGroups(seenGroups, time.Now()) {
+			resolved := make([]*Alert, 0, len(raws))
+			for _, raw := range raws {
+				if a := alertFromSnapshot(raw); a != nil {
+					resolved = append(resolved, a)
 				}
 			}
+			notifyGroup(key, nil, resolved)
 		}
-		prevState = state
+
 		time.Sleep(time.Duration(*frequency) * time.Second)
 	}
 }
 
 func main() {
-	app.Spec = "-u -t -c"
-	app.Action = action
+	app.Spec = "-t -c [-u] [--mode] [--listen-addr] [--webhook-path] [--webhook-secret] " +
+		"[--msg-template] [--resolved-template] [--group-template] [--parse-mode] [--routes-file] " +
+		"[--queue-dir] [--queue-capacity] [--queue-max-age] [--metrics-addr] [--resend-interval]"
+	app.Action = func() {
+		if *metricsAddr != "" {
+			metrics.Serve(*metricsAddr)
+		}
+
+		f, err := format.NewFormatter(*msgTemplatePath, *resolvedTemplatePath, *groupTemplatePath, format.ParseMode(*parseMode))
+		if err != nil {
+			log.Fatalln("[ERR] loading templates", err)
+		}
+		formatter = f
+
+		ri, err := time.ParseDuration(*resendIntervalFlag)
+		if err != nil {
+			log.Fatalln("[ERR] parsing --resend-interval", err)
+		}
+		resendInterval = ri
+
+		if *routesFile != "" {
+			cfg, err := route.Load(*routesFile)
+			if err != nil {
+				log.Fatalln("[ERR] loading routes file", err)
+			}
+			routingConfig = cfg
+		}
+
+		maxAge, err := time.ParseDuration(*queueMaxAge)
+		if err != nil {
+			log.Fatalln("[ERR] parsing --queue-max-age", err)
+		}
+		q, err := queue.New(*queueCapacity, maxAge, *queueDir, func(item queue.Item) queue.Result {
+			return sendTelegram(item.BotToken, item.ChatID, item.ParseMode, []byte(item.Body))
+		})
+		if err != nil {
+			log.Fatalln("[ERR] initializing queue", err)
+		}
+		notifyQueue = q
+		go notifyQueue.Run(make(chan struct{}))
+
+		statePath := ""
+		if *queueDir != "" {
+			statePath = filepath.Join(*queueDir, "alert-state.json")
+		}
+		trk, err := track.New(statePath)
+		if err != nil {
+			log.Fatalln("[ERR] initializing alert tracker", err)
+		}
+		alertTracker = trk
+
+		switch *mode {
+		case "webhook":
+			serveWebhook()
+		case "poll":
+			action()
+		default:
+			log.Fatalf("[ERR] unknown --mode %q, expected \"poll\" or \"webhook\"", *mode)
+		}
+	}
 	if err := app.Run(os.Args); err != nil {
 		log.Fatalln("[ERR]", err)
 	}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  string
+	}{
+		{
+			name: "under the limit is returned unchanged",
+			msg:  "short message",
+		},
+		{
+			name: "multi-byte rune sits exactly at the cut point",
+			// Pad so the 3-byte "€" straddles msgSizeLimit, with no
+			// newline nearby to fall back to.
+			msg: strings.Repeat("x", msgSizeLimit-1) + "€€€€",
+		},
+		{
+			name: "no newline near the cut point",
+			msg:  strings.Repeat("x", msgSizeLimit+100),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := truncate(c.msg)
+
+			if len(c.msg) < msgSizeLimit {
+				if got != c.msg {
+					t.Fatalf("expected message under the limit to be returned unchanged, got %q", got)
+				}
+				return
+			}
+
+			if !strings.HasSuffix(got, "...") {
+				t.Fatalf("expected truncated output to end in \"...\", got %q", got)
+			}
+			if !utf8.ValidString(got) {
+				t.Fatalf("truncated output is not valid UTF-8: %q", got)
+			}
+			if len(got) >= len(c.msg) {
+				t.Fatalf("expected output shorter than input, got %d >= %d bytes", len(got), len(c.msg))
+			}
+		})
+	}
+}
+
+func TestTruncateCutsOnNewlineWhenNearby(t *testing.T) {
+	row := strings.Repeat("x", 10)
+	// Build rows so the cut point falls a little past a row boundary.
+	msg := strings.Repeat(row+"\n", msgSizeLimit/len(row)+1)
+
+	got := truncate(msg)
+
+	if !strings.HasSuffix(got, "...") {
+		t.Fatalf("expected truncated output to end in \"...\", got %q", got)
+	}
+	body := strings.TrimSuffix(got, "...")
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		t.Fatalf("expected truncation to land right after a full row, got %q", got)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"prom_tg_alerts/internal/format"
+	"prom_tg_alerts/internal/queue"
+)
+
+func withWebhookSecret(t *testing.T, secret string) {
+	t.Helper()
+	orig := *webhookSecret
+	*webhookSecret = secret
+	t.Cleanup(func() { *webhookSecret = orig })
+}
+
+func TestWebhookHandlerRejectsMissingSecret(t *testing.T) {
+	withWebhookSecret(t, "s3cr3t")
+
+	req := httptest.NewRequest(http.MethodPost, *webhookPath, strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+	webhookHandler(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected %d for a missing X-Webhook-Secret, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestWebhookHandlerRejectsWrongSecret(t *testing.T) {
+	withWebhookSecret(t, "s3cr3t")
+
+	req := httptest.NewRequest(http.MethodPost, *webhookPath, strings.NewReader(`{}`))
+	req.Header.Set("X-Webhook-Secret", "wrong")
+	rec := httptest.NewRecorder()
+	webhookHandler(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected %d for a wrong X-Webhook-Secret, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestWebhookHandlerRejectsOversizedBody(t *testing.T) {
+	withWebhookSecret(t, "")
+
+	body := bytes.Repeat([]byte("a"), maxWebhookBodyBytes+1)
+	req := httptest.NewRequest(http.MethodPost, *webhookPath, bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	webhookHandler(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected %d for a body over maxWebhookBodyBytes, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+}
+
+func TestWebhookHandlerAcceptsCorrectSecret(t *testing.T) {
+	withWebhookSecret(t, "s3cr3t")
+
+	f, err := format.NewFormatter("", "", "", format.ParseModeMarkdown)
+	if err != nil {
+		t.Fatalf("NewFormatter: %v", err)
+	}
+	formatter = f
+	q, err := queue.New(0, 0, "", func(queue.Item) queue.Result { return queue.Result{OK: true} })
+	if err != nil {
+		t.Fatalf("queue.New: %v", err)
+	}
+	notifyQueue = q
+
+	req := httptest.NewRequest(http.MethodPost, *webhookPath, strings.NewReader(`{"alerts":[]}`))
+	req.Header.Set("X-Webhook-Secret", "s3cr3t")
+	rec := httptest.NewRecorder()
+	webhookHandler(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected %d for a valid webhook payload, got %d", http.StatusNoContent, rec.Code)
+	}
+}
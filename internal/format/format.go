@@ -0,0 +1,240 @@
+// Package format renders alert groups into Telegram message bodies using
+// user-supplied text/template templates, so operators can customize alert
+// phrasing without patching source.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"prom_tg_alerts/internal/labels"
+)
+
+// ParseMode mirrors Telegram's sendMessage parse_mode parameter, and
+// decides which escaping SafeMarkdown applies.
+type ParseMode string
+
+const (
+	ParseModeMarkdown   ParseMode = "Markdown"
+	ParseModeMarkdownV2 ParseMode = "MarkdownV2"
+	ParseModeHTML       ParseMode = "HTML"
+)
+
+// AlertView is the template-facing representation of a single alert.
+type AlertView struct {
+	Labels       labels.Labels
+	Annotations  labels.Labels
+	StartsAt     time.Time
+	EndsAt       time.Time
+	GeneratorURL string
+}
+
+// Status reports "resolved" once EndsAt has passed, "firing" otherwise.
+func (a AlertView) Status() string {
+	if !a.EndsAt.IsZero() && a.EndsAt.Before(time.Now()) {
+		return "resolved"
+	}
+	return "firing"
+}
+
+// GroupView is the template context for a single alert group, i.e. what
+// becomes the body of one Telegram message.
+type GroupView struct {
+	GroupKey string
+	Alerts   []AlertView
+}
+
+// Status is "resolved" only once every alert in the group is resolved.
+func (g GroupView) Status() string {
+	for _, a := range g.Alerts {
+		if a.Status() == "firing" {
+			return "firing"
+		}
+	}
+	return "resolved"
+}
+
+// CommonLabels returns the labels shared by every alert in the group.
+func (g GroupView) CommonLabels() labels.Labels {
+	if len(g.Alerts) == 0 {
+		return nil
+	}
+	common := labels.Labels{}
+	for _, l := range g.Alerts[0].Labels {
+		shared := true
+		for _, a := range g.Alerts[1:] {
+			if a.Labels.Get(l.Name) != l.Value {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			common = append(common, l)
+		}
+	}
+	return common
+}
+
+// DefaultMsgTemplate reproduces the notifier's original, hardcoded alert
+// layout: a bulleted summary and description per alert, falling back to
+// the raw annotations or labels when neither is set.
+const DefaultMsgTemplate = `{{ range $i, $a := .Alerts }}{{ if $i }}
+{{ end }}{{ AlertText $a }}{{ end }}`
+
+// DefaultGroupTemplate reproduces the original group label used in logs.
+const DefaultGroupTemplate = `{{ .GroupKey }}`
+
+// DefaultResolvedTemplate renders resolved alerts distinctly from firing
+// ones, the way Alertmanager-aware chat integrations do.
+const DefaultResolvedTemplate = `{{ range $i, $a := .Alerts }}{{ if $i }}
+{{ end }}✅ RESOLVED: {{ AlertText $a }}{{ end }}`
+
+// Formatter renders alert groups via a trio of text/template templates:
+// one for firing alerts, one for resolved alerts, one for the group's
+// display name.
+type Formatter struct {
+	msgTemplate      *template.Template
+	resolvedTemplate *template.Template
+	groupTemplate    *template.Template
+	ParseMode        ParseMode
+}
+
+// NewFormatter loads msgTemplatePath, resolvedTemplatePath, and
+// groupTemplatePath (falling back to the Default* templates when a path is
+// empty) and parses them with a FuncMap appropriate for mode.
+func NewFormatter(msgTemplatePath, resolvedTemplatePath, groupTemplatePath string, mode ParseMode) (*Formatter, error) {
+	msgSrc, err := loadOrDefault(msgTemplatePath, DefaultMsgTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("reading msg template: %w", err)
+	}
+	resolvedSrc, err := loadOrDefault(resolvedTemplatePath, DefaultResolvedTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("reading resolved template: %w", err)
+	}
+	groupSrc, err := loadOrDefault(groupTemplatePath, DefaultGroupTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("reading group template: %w", err)
+	}
+
+	fm := funcMap(mode)
+	msgTpl, err := template.New("msg").Funcs(fm).Parse(msgSrc)
+	if err != nil {
+		return nil, fmt.Errorf("parsing msg template: %w", err)
+	}
+	resolvedTpl, err := template.New("resolved").Funcs(fm).Parse(resolvedSrc)
+	if err != nil {
+		return nil, fmt.Errorf("parsing resolved template: %w", err)
+	}
+	groupTpl, err := template.New("group").Funcs(fm).Parse(groupSrc)
+	if err != nil {
+		return nil, fmt.Errorf("parsing group template: %w", err)
+	}
+
+	return &Formatter{
+		msgTemplate:      msgTpl,
+		resolvedTemplate: resolvedTpl,
+		groupTemplate:    groupTpl,
+		ParseMode:        mode,
+	}, nil
+}
+
+func loadOrDefault(path, def string) (string, error) {
+	if path == "" {
+		return def, nil
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// Render returns the Telegram message body for a group of firing alerts.
+func (f *Formatter) Render(group GroupView) (string, error) {
+	var buf bytes.Buffer
+	if err := f.msgTemplate.Execute(&buf, group); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderResolved returns the Telegram message body for a group of alerts
+// that have just resolved.
+func (f *Formatter) RenderResolved(group GroupView) (string, error) {
+	var buf bytes.Buffer
+	if err := f.resolvedTemplate.Execute(&buf, group); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// RenderGroupKey returns the display name for a group, e.g. as a log label.
+func (f *Formatter) RenderGroupKey(group GroupView) (string, error) {
+	var buf bytes.Buffer
+	if err := f.groupTemplate.Execute(&buf, group); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func funcMap(mode ParseMode) template.FuncMap {
+	return template.FuncMap{
+		"ToUpper":      strings.ToUpper,
+		"ToLower":      strings.ToLower,
+		"Join":         strings.Join,
+		"Trim":         strings.TrimSpace,
+		"Since":        time.Since,
+		"Duration":     func(d time.Duration) string { return d.Round(time.Second).String() },
+		"SafeMarkdown": func(s string) string { return escapeForParseMode(s, mode) },
+		"AlertText":    alertText,
+	}
+}
+
+// alertText reproduces the original alertToString rendering of a single
+// alert, used by DefaultMsgTemplate.
+func alertText(a AlertView) string {
+	lines := make([]string, 0)
+	if a.Annotations != nil {
+		if summary := a.Annotations.Get("summary"); len(summary) > 0 {
+			lines = append(lines, "• *"+summary+"*")
+		}
+		if description := a.Annotations.Get("description"); len(description) > 0 {
+			lines = append(lines, description)
+		}
+		if len(lines) == 0 {
+			lines = append(lines, a.Annotations.String())
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, a.Labels.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+var markdownV2Escaper = strings.NewReplacer(
+	"_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+	"~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-",
+	"=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!",
+)
+
+var markdownEscaper = strings.NewReplacer(
+	"_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[",
+)
+
+// escapeForParseMode escapes s for safe inclusion in a Telegram message
+// body under the given parse mode.
+func escapeForParseMode(s string, mode ParseMode) string {
+	switch mode {
+	case ParseModeMarkdownV2:
+		return markdownV2Escaper.Replace(s)
+	case ParseModeHTML:
+		return html.EscapeString(s)
+	default:
+		return markdownEscaper.Replace(s)
+	}
+}
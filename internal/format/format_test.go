@@ -0,0 +1,29 @@
+package format
+
+import "testing"
+
+func TestEscapeForParseMode(t *testing.T) {
+	cases := []struct {
+		mode ParseMode
+		in   string
+		want string
+	}{
+		{ParseModeMarkdown, "*bold* _ital_ `code` [link]", "\\*bold\\* \\_ital\\_ \\`code\\` \\[link]"},
+		{ParseModeMarkdownV2, "a.b-c (d)!", `a\.b\-c \(d\)\!`},
+		{ParseModeHTML, "<b>&amp;</b>", "&lt;b&gt;&amp;amp;&lt;/b&gt;"},
+	}
+
+	for _, c := range cases {
+		if got := escapeForParseMode(c.in, c.mode); got != c.want {
+			t.Errorf("escapeForParseMode(%q, %v) = %q, want %q", c.in, c.mode, got, c.want)
+		}
+	}
+}
+
+func TestEscapeForParseModeDefaultsToMarkdown(t *testing.T) {
+	got := escapeForParseMode("*bold*", ParseMode("unknown"))
+	want := `\*bold\*`
+	if got != want {
+		t.Errorf("escapeForParseMode with an unrecognized mode = %q, want %q (Markdown fallback)", got, want)
+	}
+}
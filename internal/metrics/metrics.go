@@ -0,0 +1,68 @@
+// Package metrics exposes Prometheus metrics about the notifier itself,
+// so it can be scraped by the same Prometheus it watches.
+package metrics
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// AlertsScraped counts alerts seen across successful scrapes of the
+	// Prometheus Alerts URL.
+	AlertsScraped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "promtg_alerts_scraped_total",
+		Help: "Total number of alerts returned across scrapes of the Prometheus Alerts URL.",
+	})
+
+	// ScrapeErrors counts failed scrapes of the Prometheus Alerts URL.
+	ScrapeErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "promtg_scrape_errors_total",
+		Help: "Total number of failed scrapes of the Prometheus Alerts URL.",
+	})
+
+	// TelegramSendTotal counts Telegram send attempts by result: ok, error,
+	// or ratelimited.
+	TelegramSendTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "promtg_telegram_send_total",
+		Help: "Total number of Telegram send attempts, by result.",
+	}, []string{"result"})
+
+	// TelegramSendDuration observes the latency of Telegram sendMessage calls.
+	TelegramSendDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "promtg_telegram_send_duration_seconds",
+		Help:    "Latency of Telegram sendMessage calls, in seconds.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// ActiveAlerts gauges the current alert count per group, keyed by the
+	// value of the label named by the notifier's -g flag.
+	ActiveAlerts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "promtg_active_alerts",
+		Help: "Number of currently active alerts, partitioned by the groupBy label.",
+	}, []string{"group"})
+
+	// StateChanges counts rendered group notifications, one per group for its
+	// firing alerts and one for its resolved alerts; --resend-interval
+	// heartbeat resends of still-firing alerts are counted too.
+	StateChanges = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "promtg_state_changes_total",
+		Help: "Total number of firing or resolved alert group notifications, including heartbeat resends.",
+	})
+)
+
+// Serve starts an HTTP server exposing /metrics on addr in the background.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Printf("[INFO] serving Prometheus metrics on %v/metrics\n", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalln("[ERR] metrics server", err)
+		}
+	}()
+}
@@ -0,0 +1,273 @@
+// Package queue is a durable retry queue sitting in front of Telegram
+// delivery, so alerts survive Telegram outages, rate limiting, and
+// notifier restarts instead of being lost to a fire-and-forget HTTP call.
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Item is one queued Telegram message.
+type Item struct {
+	ID            int64     `json:"id"`
+	ChatID        string    `json:"chat_id"`
+	BotToken      string    `json:"bot_token"`
+	ParseMode     string    `json:"parse_mode"`
+	Body          string    `json:"body"`
+	AttemptCount  int       `json:"attempt_count"`
+	EnqueuedAt    time.Time `json:"enqueued_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// Result reports how a single delivery attempt went, so the sender knows
+// how long to wait before retrying.
+type Result struct {
+	OK bool
+
+	// RetryAfter, when non-zero, overrides the exponential backoff with a
+	// server-specified delay (Telegram's 429 Retry-After header).
+	RetryAfter time.Duration
+}
+
+// SendFunc performs one delivery attempt for an Item.
+type SendFunc func(Item) Result
+
+const (
+	walFileName     = "queue.jsonl"
+	minBackoff      = 5 * time.Second
+	maxBackoff      = 5 * time.Minute
+	senderPollEvery = time.Second
+)
+
+// Queue is a bounded, optionally WAL-backed retry queue. A single sender
+// goroutine (started with Run) drains it with exponential backoff.
+type Queue struct {
+	mu       sync.Mutex
+	items    []Item
+	nextID   int64
+	capacity int
+	maxAge   time.Duration
+	dir      string
+	send     SendFunc
+	wake     chan struct{}
+}
+
+// New creates a Queue, replaying any WAL found in dir (if dir is non-empty)
+// so alerts queued before a restart aren't lost.
+func New(capacity int, maxAge time.Duration, dir string, send SendFunc) (*Queue, error) {
+	q := &Queue{
+		capacity: capacity,
+		maxAge:   maxAge,
+		dir:      dir,
+		send:     send,
+		wake:     make(chan struct{}, 1),
+	}
+	if dir != "" {
+		items, err := loadWAL(filepath.Join(dir, walFileName))
+		if err != nil {
+			return nil, err
+		}
+		q.items = q.dropStale(items)
+		for _, item := range q.items {
+			if item.ID >= q.nextID {
+				q.nextID = item.ID + 1
+			}
+		}
+		if len(q.items) > 0 {
+			log.Printf("[INFO] replayed %v queued alert(s) from %v\n", len(q.items), dir)
+		}
+	}
+	return q, nil
+}
+
+// Enqueue adds an item for immediate delivery, dropping the oldest queued
+// item if the queue is at capacity.
+func (q *Queue) Enqueue(chatID, botToken, parseMode, body string) {
+	q.mu.Lock()
+	now := time.Now()
+	item := Item{
+		ID:            q.nextID,
+		ChatID:        chatID,
+		BotToken:      botToken,
+		ParseMode:     parseMode,
+		Body:          body,
+		EnqueuedAt:    now,
+		NextAttemptAt: now,
+	}
+	q.nextID++
+	q.items = append(q.items, item)
+	if q.capacity > 0 && len(q.items) > q.capacity {
+		dropped := len(q.items) - q.capacity
+		log.Printf("[WARN] queue at capacity (%v), dropping %v oldest alert(s)\n", q.capacity, dropped)
+		q.items = q.items[dropped:]
+	}
+	q.persist()
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run drains the queue until stop is closed. It should be started once, in
+// its own goroutine, before the notifier begins polling or serving
+// webhooks.
+func (q *Queue) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(senderPollEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+		for q.sendNext() {
+		}
+	}
+}
+
+// sendNext attempts delivery of the oldest ready item, skipping over any
+// earlier item that's still backing off so one stalled destination can't
+// hold up delivery to every other destination. It returns true if it did
+// any work, so Run can keep draining without waiting for the next tick.
+func (q *Queue) sendNext() bool {
+	q.mu.Lock()
+	q.items = q.dropStale(q.items)
+	idx := -1
+	now := time.Now()
+	for i, item := range q.items {
+		if !item.NextAttemptAt.After(now) {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		q.mu.Unlock()
+		return false
+	}
+	item := q.items[idx]
+	q.mu.Unlock()
+
+	result := q.send(item)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	idx = q.indexByID(item.ID)
+	if idx == -1 {
+		// Queue changed underneath us (e.g. dropped for age); nothing to do.
+		return true
+	}
+	if result.OK {
+		q.items = append(q.items[:idx], q.items[idx+1:]...)
+		q.persist()
+		return true
+	}
+	item.AttemptCount++
+	item.NextAttemptAt = time.Now().Add(backoff(item.AttemptCount, result.RetryAfter))
+	q.items[idx] = item
+	q.persist()
+	return true
+}
+
+// indexByID returns the current index of the item with the given ID, or -1
+// if it's no longer queued. Must be called with q.mu held.
+func (q *Queue) indexByID(id int64) int {
+	for i, item := range q.items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (q *Queue) dropStale(items []Item) []Item {
+	if q.maxAge <= 0 {
+		return items
+	}
+	cutoff := time.Now().Add(-q.maxAge)
+	kept := items[:0:0]
+	for _, item := range items {
+		if item.EnqueuedAt.Before(cutoff) {
+			log.Printf("[WARN] dropping alert queued at %v, older than --queue-max-age\n", item.EnqueuedAt)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+// persist rewrites the WAL from the current in-memory queue. Must be
+// called with q.mu held.
+func (q *Queue) persist() {
+	if q.dir == "" {
+		return
+	}
+	path := filepath.Join(q.dir, walFileName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Println("[ERR] persisting queue WAL", err)
+		return
+	}
+	enc := json.NewEncoder(f)
+	for _, item := range q.items {
+		if err := enc.Encode(item); err != nil {
+			log.Println("[ERR] persisting queue WAL", err)
+			f.Close()
+			return
+		}
+	}
+	if err := f.Close(); err != nil {
+		log.Println("[ERR] persisting queue WAL", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Println("[ERR] persisting queue WAL", err)
+	}
+}
+
+func loadWAL(path string) ([]Item, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	items := make([]Item, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var item Item
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			log.Println("[ERR] skipping malformed queue WAL entry", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// backoff computes the delay before the next delivery attempt, honoring a
+// server-specified retryAfter (e.g. Telegram's 429 Retry-After) over the
+// default exponential backoff.
+func backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	d := minBackoff << uint(attempt-1)
+	if d > maxBackoff || d <= 0 {
+		return maxBackoff
+	}
+	return d
+}
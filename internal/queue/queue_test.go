@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSendNextSkipsStalledHead verifies that a destination backing off after
+// a failed delivery doesn't block delivery to other destinations queued
+// behind it.
+func TestSendNextSkipsStalledHead(t *testing.T) {
+	var mu sync.Mutex
+	sent := map[string]int{}
+
+	q, err := New(0, 0, "", func(item Item) Result {
+		mu.Lock()
+		sent[item.ChatID]++
+		mu.Unlock()
+		if item.ChatID == "stalled" {
+			return Result{OK: false, RetryAfter: time.Hour}
+		}
+		return Result{OK: true}
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	q.Enqueue("stalled", "tok", "Markdown", "first, will back off")
+	q.Enqueue("ok", "tok", "Markdown", "second, should still go through")
+
+	for q.sendNext() {
+	}
+
+	if sent["ok"] != 1 {
+		t.Fatalf(`expected destination "ok" to be sent once while "stalled" backs off, got %d sends`, sent["ok"])
+	}
+	if sent["stalled"] != 1 {
+		t.Fatalf(`expected one delivery attempt for "stalled", got %d`, sent["stalled"])
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) != 1 || q.items[0].ChatID != "stalled" {
+		t.Fatalf("expected only the backed-off item to remain queued, got %+v", q.items)
+	}
+	if q.items[0].AttemptCount != 1 {
+		t.Fatalf("expected stalled item's attempt count to be 1, got %d", q.items[0].AttemptCount)
+	}
+}
+
+// TestSendNextDeliversInOrderWhenAllReady verifies the common case is
+// unaffected: with nothing backing off, items are still sent oldest-first.
+func TestSendNextDeliversInOrderWhenAllReady(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+
+	q, err := New(0, 0, "", func(item Item) Result {
+		mu.Lock()
+		order = append(order, item.Body)
+		mu.Unlock()
+		return Result{OK: true}
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	q.Enqueue("a", "tok", "Markdown", "first")
+	q.Enqueue("a", "tok", "Markdown", "second")
+
+	for q.sendNext() {
+	}
+
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Fatalf("expected in-order delivery [first second], got %v", order)
+	}
+}
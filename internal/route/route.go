@@ -0,0 +1,82 @@
+// Package route resolves which Telegram chat(s) an alert group should be
+// sent to, mirroring Alertmanager's own route tree.
+package route
+
+import (
+	"io/ioutil"
+
+	"gopkg.in/yaml.v3"
+
+	"prom_tg_alerts/internal/labels"
+)
+
+// Match is a set of label name/value pairs that must all be present on an
+// alert group's labels for a Destination to apply.
+type Match map[string]string
+
+// Destination is a single chat an alert group can be sent to. BotToken and
+// ParseMode may be left empty to fall back to the notifier's defaults.
+type Destination struct {
+	Match     Match  `yaml:"match"`
+	ChatID    string `yaml:"chat_id"`
+	BotToken  string `yaml:"bot_token"`
+	ParseMode string `yaml:"parse_mode"`
+
+	// Continue lets a group fan out to further matching routes instead of
+	// stopping at the first match, e.g. a team channel plus a paging chat.
+	Continue bool `yaml:"continue"`
+}
+
+// Key identifies a Destination by its bot token and chat ID. Notification
+// state is tracked per alert group, not per Key.
+func (d Destination) Key() string {
+	return d.BotToken + "|" + d.ChatID
+}
+
+func (d Destination) matches(l labels.Labels) bool {
+	for name, value := range d.Match {
+		if l.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Config is a routing table, evaluated in order.
+type Config struct {
+	Routes  []Destination `yaml:"routes"`
+	Default *Destination  `yaml:"default"`
+}
+
+// Load parses a routing table from a YAML file.
+func Load(path string) (*Config, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(body, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Resolve returns every Destination whose Match is satisfied by l, in
+// route order, stopping after the first match whose Continue is false.
+// When nothing matches, it falls back to Default, if set.
+func (c *Config) Resolve(l labels.Labels) []Destination {
+	out := make([]Destination, 0, 1)
+	for _, d := range c.Routes {
+		if !d.matches(l) {
+			continue
+		}
+		out = append(out, d)
+		if !d.Continue {
+			return out
+		}
+	}
+	if len(out) == 0 && c.Default != nil {
+		out = append(out, *c.Default)
+	}
+	return out
+}
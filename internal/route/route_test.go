@@ -0,0 +1,64 @@
+package route
+
+import (
+	"testing"
+
+	"prom_tg_alerts/internal/labels"
+)
+
+func alertLabels(pairs ...string) labels.Labels {
+	l := labels.Labels{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		l = append(l, labels.Label{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return l
+}
+
+func TestResolveStopsAtFirstNonContinueMatch(t *testing.T) {
+	cfg := &Config{
+		Routes: []Destination{
+			{Match: Match{"team": "sre"}, ChatID: "sre-chat"},
+			{Match: Match{"team": "sre"}, ChatID: "sre-backup-chat"},
+		},
+	}
+
+	out := cfg.Resolve(alertLabels("team", "sre"))
+	if len(out) != 1 || out[0].ChatID != "sre-chat" {
+		t.Fatalf("expected only the first matching route, got %+v", out)
+	}
+}
+
+func TestResolveFansOutOnContinue(t *testing.T) {
+	cfg := &Config{
+		Routes: []Destination{
+			{Match: Match{"team": "sre"}, ChatID: "sre-chat", Continue: true},
+			{Match: Match{"team": "sre"}, ChatID: "sre-backup-chat"},
+		},
+	}
+
+	out := cfg.Resolve(alertLabels("team", "sre"))
+	if len(out) != 2 || out[0].ChatID != "sre-chat" || out[1].ChatID != "sre-backup-chat" {
+		t.Fatalf("expected both routes with Continue set, got %+v", out)
+	}
+}
+
+func TestResolveFallsBackToDefault(t *testing.T) {
+	cfg := &Config{
+		Routes:  []Destination{{Match: Match{"team": "sre"}, ChatID: "sre-chat"}},
+		Default: &Destination{ChatID: "fallback-chat"},
+	}
+
+	out := cfg.Resolve(alertLabels("team", "db"))
+	if len(out) != 1 || out[0].ChatID != "fallback-chat" {
+		t.Fatalf("expected the default destination when nothing matches, got %+v", out)
+	}
+}
+
+func TestResolveNoMatchNoDefault(t *testing.T) {
+	cfg := &Config{Routes: []Destination{{Match: Match{"team": "sre"}, ChatID: "sre-chat"}}}
+
+	out := cfg.Resolve(alertLabels("team", "db"))
+	if len(out) != 0 {
+		t.Fatalf("expected no destinations when nothing matches and there's no default, got %+v", out)
+	}
+}
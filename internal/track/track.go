@@ -0,0 +1,138 @@
+// Package track keeps per-alert-fingerprint firing state across polls, so
+// the notifier can tell newly-firing, still-firing, and resolved alerts
+// apart instead of re-sending a whole group's snapshot on any change.
+package track
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Fingerprint identifies a single alert by its label set, mirroring
+// Alertmanager's own model.Fingerprint.
+type Fingerprint string
+
+// CurrentAlert pairs a fingerprint with an opaque snapshot of the alert's
+// current content. The snapshot is caller-defined (Tracker never looks
+// inside it) and is handed back unchanged for alerts that later resolve,
+// so the caller can still render a resolved notification for them.
+type CurrentAlert struct {
+	Fingerprint Fingerprint
+	Snapshot    json.RawMessage
+}
+
+type firingAlert struct {
+	LastNotifiedAt time.Time       `json:"last_notified_at"`
+	Snapshot       json.RawMessage `json:"snapshot"`
+}
+
+// Result is the classification of one group's alerts for a single poll.
+type Result struct {
+	NewlyFiring []CurrentAlert
+	StillFiring []CurrentAlert // due for a resend heartbeat
+	Resolved    []json.RawMessage
+}
+
+// Tracker remembers which alert fingerprints were firing, per group, as of
+// the last poll.
+type Tracker struct {
+	path   string
+	groups map[string]map[Fingerprint]firingAlert
+}
+
+// New creates a Tracker, loading previously persisted state from path (if
+// non-empty) so a restart doesn't re-announce every already-firing alert
+// as newly firing.
+func New(path string) (*Tracker, error) {
+	t := &Tracker{path: path, groups: map[string]map[Fingerprint]firingAlert{}}
+	if path == "" {
+		return t, nil
+	}
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return t, nil
+	}
+	if err := json.Unmarshal(body, &t.groups); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tracker) persist() {
+	if t.path == "" {
+		return
+	}
+	body, err := json.Marshal(t.groups)
+	if err != nil {
+		return
+	}
+	_ = ioutil.WriteFile(t.path, body, 0o644)
+}
+
+// Update classifies current against what was tracked for group, applying
+// resendInterval to decide whether an already-firing alert is due for a
+// heartbeat resend (resendInterval <= 0 disables resending), and records
+// the outcome for the next call.
+func (t *Tracker) Update(group string, current []CurrentAlert, resendInterval time.Duration, now time.Time) Result {
+	prev := t.groups[group]
+	next := make(map[Fingerprint]firingAlert, len(current))
+	seen := make(map[Fingerprint]bool, len(current))
+	var res Result
+
+	for _, c := range current {
+		seen[c.Fingerprint] = true
+		prevEntry, wasFiring := prev[c.Fingerprint]
+		if !wasFiring {
+			res.NewlyFiring = append(res.NewlyFiring, c)
+			next[c.Fingerprint] = firingAlert{LastNotifiedAt: now, Snapshot: c.Snapshot}
+			continue
+		}
+		if resendInterval > 0 && now.Sub(prevEntry.LastNotifiedAt) >= resendInterval {
+			res.StillFiring = append(res.StillFiring, c)
+			next[c.Fingerprint] = firingAlert{LastNotifiedAt: now, Snapshot: c.Snapshot}
+			continue
+		}
+		next[c.Fingerprint] = firingAlert{LastNotifiedAt: prevEntry.LastNotifiedAt, Snapshot: c.Snapshot}
+	}
+	for fp, entry := range prev {
+		if !seen[fp] {
+			res.Resolved = append(res.Resolved, entry.Snapshot)
+		}
+	}
+
+	if len(next) == 0 {
+		delete(t.groups, group)
+	} else {
+		t.groups[group] = next
+	}
+	t.persist()
+	return res
+}
+
+// PruneGroups resolves every fingerprint tracked under a group key that
+// wasn't present at all in the latest poll, i.e. the group itself vanished
+// rather than any one alert within it resolving.
+func (t *Tracker) PruneGroups(seenGroups map[string]bool, now time.Time) map[string][]json.RawMessage {
+	resolved := map[string][]json.RawMessage{}
+	for group, fps := range t.groups {
+		if seenGroups[group] {
+			continue
+		}
+		for _, entry := range fps {
+			resolved[group] = append(resolved[group], entry.Snapshot)
+		}
+		delete(t.groups, group)
+	}
+	if len(resolved) > 0 {
+		t.persist()
+	}
+	return resolved
+}
@@ -0,0 +1,101 @@
+package track
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func snapshot(t *testing.T, v string) json.RawMessage {
+	t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal snapshot: %v", err)
+	}
+	return body
+}
+
+func TestUpdateClassifiesNewlyFiringStillFiringAndResolved(t *testing.T) {
+	tr, err := New("")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	now := time.Now()
+
+	res := tr.Update("group", []CurrentAlert{
+		{Fingerprint: "fp1", Snapshot: snapshot(t, "fp1")},
+		{Fingerprint: "fp2", Snapshot: snapshot(t, "fp2")},
+	}, 0, now)
+	if len(res.NewlyFiring) != 2 || len(res.StillFiring) != 0 || len(res.Resolved) != 0 {
+		t.Fatalf("first poll: expected 2 newly firing, got %+v", res)
+	}
+
+	// fp1 drops out, fp2 is still firing but resendInterval is 0 (disabled).
+	res = tr.Update("group", []CurrentAlert{
+		{Fingerprint: "fp2", Snapshot: snapshot(t, "fp2")},
+	}, 0, now.Add(time.Minute))
+	if len(res.NewlyFiring) != 0 {
+		t.Fatalf("expected no newly firing, got %+v", res.NewlyFiring)
+	}
+	if len(res.StillFiring) != 0 {
+		t.Fatalf("expected no still-firing resends with resendInterval disabled, got %+v", res.StillFiring)
+	}
+	if len(res.Resolved) != 1 {
+		t.Fatalf("expected fp1 to resolve, got %+v", res.Resolved)
+	}
+}
+
+func TestUpdateResendsStillFiringAfterResendInterval(t *testing.T) {
+	tr, err := New("")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	now := time.Now()
+	resendInterval := time.Hour
+
+	tr.Update("group", []CurrentAlert{{Fingerprint: "fp1", Snapshot: snapshot(t, "fp1")}}, resendInterval, now)
+
+	// Too soon for a resend.
+	res := tr.Update("group", []CurrentAlert{{Fingerprint: "fp1", Snapshot: snapshot(t, "fp1")}}, resendInterval, now.Add(time.Minute))
+	if len(res.StillFiring) != 0 {
+		t.Fatalf("expected no resend before resendInterval elapses, got %+v", res.StillFiring)
+	}
+
+	// Past the resend interval.
+	res = tr.Update("group", []CurrentAlert{{Fingerprint: "fp1", Snapshot: snapshot(t, "fp1")}}, resendInterval, now.Add(2*time.Hour))
+	if len(res.StillFiring) != 1 {
+		t.Fatalf("expected a resend once resendInterval elapses, got %+v", res.StillFiring)
+	}
+}
+
+func TestPruneGroupsOnlyResolvesVanishedGroups(t *testing.T) {
+	tr, err := New("")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	now := time.Now()
+
+	tr.Update("group-a", []CurrentAlert{{Fingerprint: "fp1", Snapshot: snapshot(t, "fp1")}}, 0, now)
+	tr.Update("group-b", []CurrentAlert{{Fingerprint: "fp2", Snapshot: snapshot(t, "fp2")}}, 0, now)
+
+	resolved := tr.PruneGroups(map[string]bool{"group-a": true}, now)
+	if _, stillTracked := resolved["group-a"]; stillTracked {
+		t.Fatalf("group-a was seen this poll and should not be pruned, got %+v", resolved)
+	}
+	if len(resolved["group-b"]) != 1 {
+		t.Fatalf("expected group-b's single alert to resolve, got %+v", resolved)
+	}
+
+	// A second prune call with group-a still reported as seen should be a
+	// genuine no-op: group-b was already removed, and group-a hasn't vanished.
+	resolved = tr.PruneGroups(map[string]bool{"group-a": true}, now)
+	if len(resolved) != 0 {
+		t.Fatalf("expected nothing left to prune, got %+v", resolved)
+	}
+
+	// Once group-a itself stops being reported, it resolves too.
+	resolved = tr.PruneGroups(map[string]bool{}, now)
+	if len(resolved["group-a"]) != 1 {
+		t.Fatalf("expected group-a's alert to resolve once the group vanishes, got %+v", resolved)
+	}
+}